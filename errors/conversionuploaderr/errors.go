@@ -0,0 +1,216 @@
+// Package conversionuploaderr turns the raw
+// ConversionUploadErrorEnum_ConversionUploadError codes produced by the
+// Google Ads ConversionUploadService into idiomatic Go errors that work
+// with errors.Is/errors.As, and provides helpers to classify and extract
+// them from a failed RPC's google.rpc.Status.
+package conversionuploaderr
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/golang/protobuf/ptypes"
+	errorspb "google.golang.org/genproto/googleapis/ads/googleads/v1/errors"
+	"google.golang.org/genproto/googleapis/rpc/status"
+)
+
+// Sentinel errors, one per ConversionUploadErrorEnum_ConversionUploadError
+// value. Wrap these with errors.Is against an error returned by this
+// package, or compare the Code field on a *ConversionUploadError directly.
+var (
+	ErrUnspecified                                                          = errors.New("conversionuploaderr: unspecified conversion upload error")
+	ErrUnknown                                                              = errors.New("conversionuploaderr: unknown conversion upload error")
+	ErrTooManyConversionsInRequest                                          = errors.New("conversionuploaderr: request contained more than 2000 conversions")
+	ErrUnparseableGclid                                                     = errors.New("conversionuploaderr: gclid could not be decoded")
+	ErrConversionPrecedesGclid                                              = errors.New("conversionuploaderr: conversion_date_time precedes the gclid's click time")
+	ErrExpiredGclid                                                         = errors.New("conversionuploaderr: gclid click is too old, or outside the lookback window, to import")
+	ErrTooRecentGclid                                                       = errors.New("conversionuploaderr: gclid click occurred too recently; retry after 6h")
+	ErrGclidNotFound                                                        = errors.New("conversionuploaderr: gclid not found")
+	ErrUnauthorizedCustomer                                                 = errors.New("conversionuploaderr: gclid belongs to a customer the caller does not manage")
+	ErrInvalidConversionAction                                              = errors.New("conversionuploaderr: no upload eligible conversion action matches the provided information")
+	ErrTooRecentConversionAction                                            = errors.New("conversionuploaderr: conversion action was created too recently; retry after 4-6h")
+	ErrConversionTrackingNotEnabledAtImpressionTime                         = errors.New("conversionuploaderr: click does not contain conversion tracking information")
+	ErrExternalAttributionDataSetForNonExternallyAttributedConversionAction = errors.New("conversionuploaderr: external_attribution_data set on a non-externally-attributed conversion action")
+	ErrExternalAttributionDataNotSetForExternallyAttributedConversionAction = errors.New("conversionuploaderr: external_attribution_data not fully set for an externally attributed conversion action")
+	ErrOrderIDNotPermittedForExternallyAttributedConversionAction           = errors.New("conversionuploaderr: order_id not permitted for an externally attributed conversion action")
+	ErrOrderIDAlreadyInUse                                                  = errors.New("conversionuploaderr: order_id and conversion action combination already uploaded")
+	ErrDuplicateOrderID                                                     = errors.New("conversionuploaderr: request contained duplicate order_id and conversion action combinations")
+	ErrTooRecentCall                                                        = errors.New("conversionuploaderr: call occurred too recently; retry after 6h")
+	ErrExpiredCall                                                          = errors.New("conversionuploaderr: click that initiated the call is too old to import")
+	ErrCallNotFound                                                         = errors.New("conversionuploaderr: call, or the click leading to it, was not found")
+	ErrConversionPrecedesCall                                               = errors.New("conversionuploaderr: conversion_date_time precedes the call_start_date_time")
+	ErrConversionTrackingNotEnabledAtCallTime                               = errors.New("conversionuploaderr: click that led to the call does not contain conversion tracking information")
+	ErrUnparseableCallersPhoneNumber                                        = errors.New("conversionuploaderr: caller's phone number could not be parsed")
+)
+
+var sentinelByCode = map[errorspb.ConversionUploadErrorEnum_ConversionUploadError]error{
+	errorspb.ConversionUploadErrorEnum_UNSPECIFIED:                             ErrUnspecified,
+	errorspb.ConversionUploadErrorEnum_UNKNOWN:                                 ErrUnknown,
+	errorspb.ConversionUploadErrorEnum_TOO_MANY_CONVERSIONS_IN_REQUEST:         ErrTooManyConversionsInRequest,
+	errorspb.ConversionUploadErrorEnum_UNPARSEABLE_GCLID:                       ErrUnparseableGclid,
+	errorspb.ConversionUploadErrorEnum_CONVERSION_PRECEDES_GCLID:               ErrConversionPrecedesGclid,
+	errorspb.ConversionUploadErrorEnum_EXPIRED_GCLID:                           ErrExpiredGclid,
+	errorspb.ConversionUploadErrorEnum_TOO_RECENT_GCLID:                        ErrTooRecentGclid,
+	errorspb.ConversionUploadErrorEnum_GCLID_NOT_FOUND:                         ErrGclidNotFound,
+	errorspb.ConversionUploadErrorEnum_UNAUTHORIZED_CUSTOMER:                   ErrUnauthorizedCustomer,
+	errorspb.ConversionUploadErrorEnum_INVALID_CONVERSION_ACTION:               ErrInvalidConversionAction,
+	errorspb.ConversionUploadErrorEnum_TOO_RECENT_CONVERSION_ACTION:            ErrTooRecentConversionAction,
+	errorspb.ConversionUploadErrorEnum_CONVERSION_TRACKING_NOT_ENABLED_AT_IMPRESSION_TIME:                            ErrConversionTrackingNotEnabledAtImpressionTime,
+	errorspb.ConversionUploadErrorEnum_EXTERNAL_ATTRIBUTION_DATA_SET_FOR_NON_EXTERNALLY_ATTRIBUTED_CONVERSION_ACTION: ErrExternalAttributionDataSetForNonExternallyAttributedConversionAction,
+	errorspb.ConversionUploadErrorEnum_EXTERNAL_ATTRIBUTION_DATA_NOT_SET_FOR_EXTERNALLY_ATTRIBUTED_CONVERSION_ACTION: ErrExternalAttributionDataNotSetForExternallyAttributedConversionAction,
+	errorspb.ConversionUploadErrorEnum_ORDER_ID_NOT_PERMITTED_FOR_EXTERNALLY_ATTRIBUTED_CONVERSION_ACTION:            ErrOrderIDNotPermittedForExternallyAttributedConversionAction,
+	errorspb.ConversionUploadErrorEnum_ORDER_ID_ALREADY_IN_USE:                      ErrOrderIDAlreadyInUse,
+	errorspb.ConversionUploadErrorEnum_DUPLICATE_ORDER_ID:                           ErrDuplicateOrderID,
+	errorspb.ConversionUploadErrorEnum_TOO_RECENT_CALL:                              ErrTooRecentCall,
+	errorspb.ConversionUploadErrorEnum_EXPIRED_CALL:                                 ErrExpiredCall,
+	errorspb.ConversionUploadErrorEnum_CALL_NOT_FOUND:                               ErrCallNotFound,
+	errorspb.ConversionUploadErrorEnum_CONVERSION_PRECEDES_CALL:                     ErrConversionPrecedesCall,
+	errorspb.ConversionUploadErrorEnum_CONVERSION_TRACKING_NOT_ENABLED_AT_CALL_TIME: ErrConversionTrackingNotEnabledAtCallTime,
+	errorspb.ConversionUploadErrorEnum_UNPARSEABLE_CALLERS_PHONE_NUMBER:             ErrUnparseableCallersPhoneNumber,
+}
+
+func sentinelFor(code errorspb.ConversionUploadErrorEnum_ConversionUploadError) error {
+	if s, ok := sentinelByCode[code]; ok {
+		return s
+	}
+	return ErrUnknown
+}
+
+// ConversionUploadError describes a single failing row from a
+// UploadClickConversions or UploadCallConversions batch.
+type ConversionUploadError struct {
+	// Code is the raw enum value returned by the API.
+	Code errorspb.ConversionUploadErrorEnum_ConversionUploadError
+	// ConversionIndex is the position of the offending conversion within
+	// the request batch, as reported in the error's field path.
+	ConversionIndex int32
+	// ConversionActionResourceName is the conversion action the row was
+	// uploaded against, when known.
+	ConversionActionResourceName string
+	// CallerID is the gclid (click conversions) or caller phone number
+	// (call conversions) that the row was uploaded with, when known.
+	CallerID string
+	// Message is the server-provided error message, if any.
+	Message string
+}
+
+func (e *ConversionUploadError) Error() string {
+	msg := e.Message
+	if msg == "" {
+		msg = sentinelFor(e.Code).Error()
+	}
+	if e.ConversionActionResourceName == "" {
+		return fmt.Sprintf("conversion[%d] %q: %s", e.ConversionIndex, e.CallerID, msg)
+	}
+	return fmt.Sprintf("conversion[%d] %q (action %s): %s", e.ConversionIndex, e.CallerID, e.ConversionActionResourceName, msg)
+}
+
+// Unwrap exposes the sentinel error for Code so that errors.Is(err,
+// ErrTooRecentGclid) etc. work against a *ConversionUploadError.
+func (e *ConversionUploadError) Unwrap() error {
+	return sentinelFor(e.Code)
+}
+
+// WithContext returns a copy of e annotated with the conversion action and
+// gclid/phone number it belongs to, so that a ConversionUploadError built
+// from FromStatus (which only sees the wire error, not the original
+// request) can be correlated back to its source row.
+func (e ConversionUploadError) WithContext(conversionActionResourceName, callerID string) *ConversionUploadError {
+	e.ConversionActionResourceName = conversionActionResourceName
+	e.CallerID = callerID
+	return &e
+}
+
+// Classify reports how a caller should handle err. It unwraps err (via
+// errors.As) to a *ConversionUploadError and inspects its Code.
+//
+// TOO_RECENT_GCLID and TOO_RECENT_CALL are retryable after 6h, since the
+// API will keep rejecting the click/call until then. TOO_RECENT_CONVERSION_ACTION
+// is retryable after 5h, the midpoint of the documented 4-6h window.
+// UNPARSEABLE_GCLID, INVALID_CONVERSION_ACTION, ORDER_ID_ALREADY_IN_USE,
+// DUPLICATE_ORDER_ID, and UNAUTHORIZED_CUSTOMER are permanent: retrying
+// without changing the row will never succeed. Everything else is neither
+// (the caller should treat it as a hard failure worth surfacing, but this
+// package makes no retry claim about it).
+func Classify(err error) (retryable bool, retryAfter time.Duration, permanent bool) {
+	var cuErr *ConversionUploadError
+	if !errors.As(err, &cuErr) {
+		return false, 0, false
+	}
+	switch cuErr.Code {
+	case errorspb.ConversionUploadErrorEnum_TOO_RECENT_GCLID,
+		errorspb.ConversionUploadErrorEnum_TOO_RECENT_CALL:
+		return true, 6 * time.Hour, false
+	case errorspb.ConversionUploadErrorEnum_TOO_RECENT_CONVERSION_ACTION:
+		return true, 5 * time.Hour, false
+	case errorspb.ConversionUploadErrorEnum_UNPARSEABLE_GCLID,
+		errorspb.ConversionUploadErrorEnum_INVALID_CONVERSION_ACTION,
+		errorspb.ConversionUploadErrorEnum_ORDER_ID_ALREADY_IN_USE,
+		errorspb.ConversionUploadErrorEnum_DUPLICATE_ORDER_ID,
+		errorspb.ConversionUploadErrorEnum_UNAUTHORIZED_CUSTOMER:
+		return false, 0, true
+	default:
+		return false, 0, false
+	}
+}
+
+// conversionFieldNames are the batch-field names that carry a per-row
+// index in a GoogleAdsError's field path, across the click and call
+// conversion upload RPCs.
+var conversionFieldNames = map[string]bool{
+	"conversions":       true,
+	"click_conversions": true,
+	"call_conversions":  true,
+}
+
+// FromStatus walks the google.rpc.Status details of a failed
+// UploadClickConversions/UploadCallConversions RPC and returns one
+// ConversionUploadError per GoogleAdsError whose ErrorCode carries a
+// ConversionUploadError. Fields only available from the original request
+// (the gclid/phone number, the conversion action resource name) are left
+// empty; callers should fill them in with WithContext once the
+// ConversionIndex is used to look up the source row.
+func FromStatus(s *status.Status) []ConversionUploadError {
+	if s == nil {
+		return nil
+	}
+	var out []ConversionUploadError
+	for _, d := range s.GetDetails() {
+		failure := &errorspb.GoogleAdsFailure{}
+		if err := ptypes.UnmarshalAny(d, failure); err != nil {
+			continue
+		}
+		for _, gaErr := range failure.GetErrors() {
+			code, ok := gaErr.GetErrorCode().(*errorspb.GoogleAdsError_ConversionUploadError)
+			if !ok {
+				continue
+			}
+			out = append(out, ConversionUploadError{
+				Code:            code.ConversionUploadError,
+				ConversionIndex: conversionIndex(gaErr.GetLocation()),
+				Message:         gaErr.GetMessage(),
+			})
+		}
+	}
+	return out
+}
+
+// conversionIndex returns the per-row index carried by loc, or -1 if loc
+// doesn't point into one of the conversion batch fields, or points into
+// one without an index. index is a google.protobuf.Int64Value wrapper
+// (not a plain int32), since the field is optional and 0 is a valid
+// index that must be distinguished from "absent".
+func conversionIndex(loc *errorspb.ErrorLocation) int32 {
+	for _, el := range loc.GetFieldPathElements() {
+		if !conversionFieldNames[el.GetFieldName()] {
+			continue
+		}
+		idx := el.GetIndex()
+		if idx == nil {
+			return -1
+		}
+		return int32(idx.GetValue())
+	}
+	return -1
+}
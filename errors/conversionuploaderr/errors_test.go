@@ -0,0 +1,149 @@
+package conversionuploaderr
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/ptypes"
+	errorspb "google.golang.org/genproto/googleapis/ads/googleads/v1/errors"
+	"google.golang.org/genproto/googleapis/rpc/status"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+func TestClassify(t *testing.T) {
+	cases := []struct {
+		name           string
+		code           errorspb.ConversionUploadErrorEnum_ConversionUploadError
+		wantRetryable  bool
+		wantRetryAfter time.Duration
+		wantPermanent  bool
+	}{
+		{"too_recent_gclid", errorspb.ConversionUploadErrorEnum_TOO_RECENT_GCLID, true, 6 * time.Hour, false},
+		{"too_recent_call", errorspb.ConversionUploadErrorEnum_TOO_RECENT_CALL, true, 6 * time.Hour, false},
+		{"too_recent_conversion_action", errorspb.ConversionUploadErrorEnum_TOO_RECENT_CONVERSION_ACTION, true, 5 * time.Hour, false},
+		{"unparseable_gclid", errorspb.ConversionUploadErrorEnum_UNPARSEABLE_GCLID, false, 0, true},
+		{"invalid_conversion_action", errorspb.ConversionUploadErrorEnum_INVALID_CONVERSION_ACTION, false, 0, true},
+		{"order_id_already_in_use", errorspb.ConversionUploadErrorEnum_ORDER_ID_ALREADY_IN_USE, false, 0, true},
+		{"duplicate_order_id", errorspb.ConversionUploadErrorEnum_DUPLICATE_ORDER_ID, false, 0, true},
+		{"unauthorized_customer", errorspb.ConversionUploadErrorEnum_UNAUTHORIZED_CUSTOMER, false, 0, true},
+		{"gclid_not_found", errorspb.ConversionUploadErrorEnum_GCLID_NOT_FOUND, false, 0, false},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := &ConversionUploadError{Code: tc.code}
+			retryable, retryAfter, permanent := Classify(err)
+			if retryable != tc.wantRetryable || retryAfter != tc.wantRetryAfter || permanent != tc.wantPermanent {
+				t.Errorf("Classify(%v) = (%v, %v, %v), want (%v, %v, %v)",
+					tc.code, retryable, retryAfter, permanent, tc.wantRetryable, tc.wantRetryAfter, tc.wantPermanent)
+			}
+		})
+	}
+}
+
+func TestClassifyNonConversionUploadError(t *testing.T) {
+	retryable, retryAfter, permanent := Classify(errors.New("boom"))
+	if retryable || retryAfter != 0 || permanent {
+		t.Errorf("Classify(generic error) = (%v, %v, %v), want all zero values", retryable, retryAfter, permanent)
+	}
+}
+
+func TestConversionUploadErrorUnwrap(t *testing.T) {
+	err := &ConversionUploadError{Code: errorspb.ConversionUploadErrorEnum_TOO_RECENT_GCLID}
+	if !errors.Is(err, ErrTooRecentGclid) {
+		t.Error("errors.Is(err, ErrTooRecentGclid) = false, want true")
+	}
+	if errors.Is(err, ErrUnparseableGclid) {
+		t.Error("errors.Is(err, ErrUnparseableGclid) = true, want false")
+	}
+}
+
+func TestWithContext(t *testing.T) {
+	base := ConversionUploadError{Code: errorspb.ConversionUploadErrorEnum_UNPARSEABLE_GCLID, ConversionIndex: 3}
+	annotated := base.WithContext("customers/1/conversionActions/2", "gclid-abc")
+	if annotated.ConversionActionResourceName != "customers/1/conversionActions/2" || annotated.CallerID != "gclid-abc" {
+		t.Errorf("WithContext did not set fields: %+v", annotated)
+	}
+	if base.ConversionActionResourceName != "" || base.CallerID != "" {
+		t.Errorf("WithContext mutated the receiver, got %+v", base)
+	}
+}
+
+func buildGoogleAdsFailureStatus(t *testing.T, index int64, code errorspb.ConversionUploadErrorEnum_ConversionUploadError, message string) *status.Status {
+	t.Helper()
+	failure := &errorspb.GoogleAdsFailure{
+		Errors: []*errorspb.GoogleAdsError{
+			{
+				ErrorCode: &errorspb.GoogleAdsError_ConversionUploadError{ConversionUploadError: code},
+				Message:   message,
+				Location: &errorspb.ErrorLocation{
+					FieldPathElements: []*errorspb.ErrorLocation_FieldPathElement{
+						{FieldName: "conversions", Index: wrapperspb.Int64(index)},
+					},
+				},
+			},
+		},
+	}
+	anyMsg, err := ptypes.MarshalAny(failure)
+	if err != nil {
+		t.Fatalf("MarshalAny: %v", err)
+	}
+	st := &status.Status{}
+	st.Details = append(st.Details, anyMsg)
+	return st
+}
+
+func TestFromStatus(t *testing.T) {
+	st := buildGoogleAdsFailureStatus(t, 2, errorspb.ConversionUploadErrorEnum_TOO_RECENT_GCLID, "too recent")
+
+	got := FromStatus(st)
+	if len(got) != 1 {
+		t.Fatalf("FromStatus returned %d errors, want 1", len(got))
+	}
+	if got[0].Code != errorspb.ConversionUploadErrorEnum_TOO_RECENT_GCLID {
+		t.Errorf("Code = %v, want TOO_RECENT_GCLID", got[0].Code)
+	}
+	if got[0].ConversionIndex != 2 {
+		t.Errorf("ConversionIndex = %d, want 2", got[0].ConversionIndex)
+	}
+	if got[0].Message != "too recent" {
+		t.Errorf("Message = %q, want %q", got[0].Message, "too recent")
+	}
+}
+
+func TestFromStatusMissingIndex(t *testing.T) {
+	failure := &errorspb.GoogleAdsFailure{
+		Errors: []*errorspb.GoogleAdsError{
+			{
+				ErrorCode: &errorspb.GoogleAdsError_ConversionUploadError{
+					ConversionUploadError: errorspb.ConversionUploadErrorEnum_UNPARSEABLE_GCLID,
+				},
+				Location: &errorspb.ErrorLocation{
+					FieldPathElements: []*errorspb.ErrorLocation_FieldPathElement{
+						{FieldName: "conversions"},
+					},
+				},
+			},
+		},
+	}
+	anyMsg, err := ptypes.MarshalAny(failure)
+	if err != nil {
+		t.Fatalf("MarshalAny: %v", err)
+	}
+	st := &status.Status{}
+	st.Details = append(st.Details, anyMsg)
+
+	got := FromStatus(st)
+	if len(got) != 1 {
+		t.Fatalf("FromStatus returned %d errors, want 1", len(got))
+	}
+	if got[0].ConversionIndex != -1 {
+		t.Errorf("ConversionIndex = %d, want -1 for a location without an index", got[0].ConversionIndex)
+	}
+}
+
+func TestFromStatusNil(t *testing.T) {
+	if got := FromStatus(nil); got != nil {
+		t.Errorf("FromStatus(nil) = %v, want nil", got)
+	}
+}
@@ -0,0 +1,253 @@
+package conversionuploader
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/miaoyijin/dd_time/errors/conversionuploaderr"
+	servicespb "google.golang.org/genproto/googleapis/ads/googleads/v1/services"
+)
+
+// PendingCallConversion is a single call conversion row waiting out a
+// scheduled retry, persisted the same way PendingConversion is for click
+// conversions.
+type PendingCallConversion struct {
+	// Key uniquely identifies this row for the Store; see callRetryKey.
+	Key        string
+	Conversion *servicespb.CallConversion
+	NotBefore  time.Time
+	Reason     error
+	// Attempt is how many times this row has already been scheduled for
+	// retry; see PendingConversion.Attempt.
+	Attempt int
+}
+
+// callRetryKey identifies a call conversion for the retry Store. Unlike
+// the (order_id, conversion_action) key dedupeCallConversions uses, it
+// also folds in the caller_id: order_id is commonly empty for calls keyed
+// by caller_id and call time, and two such rows that share a conversion
+// action must not collide in the Store the way they're deliberately
+// allowed to pass through dedup together.
+func callRetryKey(conv *servicespb.CallConversion) string {
+	return conv.GetOrderId() + "\x00" + conv.GetCallerId() + "\x00" + conv.GetConversionAction()
+}
+
+// UploadCallConversions normalizes caller IDs with normalizer per policy,
+// then uploads the remaining rows the same way UploadClickConversions
+// does: chunked, deduplicated by (order_id, conversion_action), and
+// reporting partial failures through Result. Rows dropped or left to fail
+// locally by the normalizer are reported as PermanentlyRejected alongside
+// any server-side rejections, so callers see one Result regardless of
+// where a row was rejected.
+func (c *Client) UploadCallConversions(ctx context.Context, normalizer *PhoneNormalizer, policy Policy, conversions []*servicespb.CallConversion) (*Result, error) {
+	req := &servicespb.UploadCallConversionsRequest{Conversions: conversions}
+	parseErrs := normalizer.NormalizeRequest(req, policy)
+
+	// AbortBatch means exactly that: on any parse failure, req is left
+	// untouched and no RPC is issued, so the batch that contains the bad
+	// row never goes out.
+	if policy == AbortBatch && len(parseErrs) > 0 {
+		rows := make([]RowResult, 0, len(parseErrs))
+		for _, pe := range parseErrs {
+			pe := pe
+			rows = append(rows, RowResult{Outcome: PermanentlyRejected, Reason: &pe})
+		}
+		return &Result{Rows: rows}, nil
+	}
+
+	localRows := make([]RowResult, 0, len(parseErrs))
+	if policy == Drop {
+		for _, pe := range parseErrs {
+			pe := pe
+			localRows = append(localRows, RowResult{Outcome: PermanentlyRejected, Reason: &pe})
+		}
+	}
+
+	result, err := c.uploadCallConversions(ctx, req.GetConversions(), nil)
+	result.Rows = append(localRows, result.Rows...)
+	return result, err
+}
+
+// uploadCallConversions is UploadCallConversions without the phone
+// normalization step, for re-uploading rows a previous call already
+// normalized (DrainDueRetries). attempts carries forward how many times
+// each row has already been retried, keyed by callRetryKey, so the
+// backoff keeps growing instead of resetting on every drain.
+func (c *Client) uploadCallConversions(ctx context.Context, conversions []*servicespb.CallConversion, attempts map[string]int) (*Result, error) {
+	deduped, dupes := dedupeCallConversions(conversions)
+	chunks := chunkCallConversions(deduped, maxConversionsPerRequest)
+
+	var (
+		mu       sync.Mutex
+		wg       sync.WaitGroup
+		rows     = make([]RowResult, 0, len(deduped))
+		firstErr error
+	)
+
+	sem := make(chan struct{}, c.parallelism)
+	for _, chunk := range chunks {
+		chunk := chunk
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			chunkRows, err := c.uploadCallChunk(ctx, chunk, attempts)
+
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil && firstErr == nil {
+				firstErr = err
+			}
+			rows = append(rows, chunkRows...)
+		}()
+	}
+	wg.Wait()
+
+	for _, d := range dupes {
+		rows = append(rows, RowResult{
+			OrderID:          d.GetOrderId(),
+			ConversionAction: d.GetConversionAction(),
+			Outcome:          PermanentlyRejected,
+			Reason:           conversionuploaderr.ErrDuplicateOrderID,
+			key:              callRetryKey(d),
+		})
+	}
+	return &Result{Rows: rows}, firstErr
+}
+
+func (c *Client) uploadCallChunk(ctx context.Context, chunk []*servicespb.CallConversion, attempts map[string]int) ([]RowResult, error) {
+	resp, err := c.svc.UploadCallConversions(ctx, &servicespb.UploadCallConversionsRequest{
+		CustomerId:     c.customerID,
+		Conversions:    chunk,
+		PartialFailure: true,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// See the matching comment in uploadChunk: a failure with no
+	// conversion index can't be attributed to any row in chunk, so it's
+	// surfaced as a chunk-level error instead of silently discarded.
+	failureByIndex := make(map[int32]conversionuploaderr.ConversionUploadError)
+	var unindexed []conversionuploaderr.ConversionUploadError
+	for _, f := range conversionuploaderr.FromStatus(resp.GetPartialFailureError()) {
+		if f.ConversionIndex < 0 {
+			unindexed = append(unindexed, f)
+			continue
+		}
+		failureByIndex[f.ConversionIndex] = f
+	}
+
+	rows := make([]RowResult, 0, len(chunk))
+	for i, conv := range chunk {
+		key := callRetryKey(conv)
+		row := RowResult{OrderID: conv.GetOrderId(), ConversionAction: conv.GetConversionAction(), key: key}
+
+		failure, failed := failureByIndex[int32(i)]
+		if !failed {
+			row.Outcome = Accepted
+			rows = append(rows, row)
+			continue
+		}
+
+		cuErr := failure.WithContext(conv.GetConversionAction(), conv.GetCallerId())
+		row.Reason = cuErr
+		if retryable, retryAfter, _ := conversionuploaderr.Classify(cuErr); retryable {
+			attempt := attempts[key]
+			row.Outcome = PendingRetry
+			row.RetryAt = time.Now().Add(backoffDelay(retryAfter, attempt))
+			if c.store != nil {
+				_ = c.store.SaveCall(ctx, PendingCallConversion{Key: key, Conversion: conv, NotBefore: row.RetryAt, Reason: cuErr, Attempt: attempt + 1})
+			}
+		} else {
+			row.Outcome = PermanentlyRejected
+		}
+		rows = append(rows, row)
+	}
+
+	var chunkErr error
+	if len(unindexed) > 0 {
+		chunkErr = fmt.Errorf("conversionuploader: %d partial failure(s) with no conversion index, e.g. %w", len(unindexed), &unindexed[0])
+	}
+	return rows, chunkErr
+}
+
+// drainDueCallRetries is DrainDueRetries' call-conversion half: it
+// re-uploads every Store row whose NotBefore has passed, without running
+// the rows back through NormalizeRequest (they were already normalized
+// before their first upload attempt).
+func (c *Client) drainDueCallRetries(ctx context.Context) (*Result, error) {
+	pending, err := c.store.LoadCalls(ctx)
+	if err != nil {
+		return &Result{}, fmt.Errorf("conversionuploader: loading pending call retries: %w", err)
+	}
+
+	now := time.Now()
+	due := make([]*servicespb.CallConversion, 0, len(pending))
+	attempts := make(map[string]int, len(pending))
+	for _, p := range pending {
+		if now.Before(p.NotBefore) {
+			continue
+		}
+		due = append(due, p.Conversion)
+		attempts[callRetryKey(p.Conversion)] = p.Attempt
+	}
+	if len(due) == 0 {
+		return &Result{}, nil
+	}
+
+	result, err := c.uploadCallConversions(ctx, due, attempts)
+	if err != nil {
+		return result, err
+	}
+	for _, row := range result.Rows {
+		if row.Outcome == PendingRetry {
+			continue
+		}
+		_ = c.store.DeleteCall(ctx, row.key)
+	}
+	return result, nil
+}
+
+// dedupeCallConversions drops rows that collide on (order_id,
+// conversion_action). Call conversions are commonly keyed by caller_id
+// and call time with no order_id, and only a non-empty order_id can
+// trigger DUPLICATE_ORDER_ID, so rows without one are never deduplicated
+// against each other.
+func dedupeCallConversions(in []*servicespb.CallConversion) (deduped, dupes []*servicespb.CallConversion) {
+	seen := make(map[string]bool, len(in))
+	for _, conv := range in {
+		orderID := conv.GetOrderId()
+		if orderID == "" {
+			deduped = append(deduped, conv)
+			continue
+		}
+		key := orderID + "\x00" + conv.GetConversionAction()
+		if seen[key] {
+			dupes = append(dupes, conv)
+			continue
+		}
+		seen[key] = true
+		deduped = append(deduped, conv)
+	}
+	return deduped, dupes
+}
+
+func chunkCallConversions(in []*servicespb.CallConversion, size int) [][]*servicespb.CallConversion {
+	if len(in) == 0 {
+		return nil
+	}
+	chunks := make([][]*servicespb.CallConversion, 0, (len(in)+size-1)/size)
+	for i := 0; i < len(in); i += size {
+		end := i + size
+		if end > len(in) {
+			end = len(in)
+		}
+		chunks = append(chunks, in[i:end])
+	}
+	return chunks
+}
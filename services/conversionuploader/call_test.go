@@ -0,0 +1,61 @@
+package conversionuploader
+
+import (
+	"context"
+	"testing"
+
+	errorspb "google.golang.org/genproto/googleapis/ads/googleads/v1/errors"
+	servicespb "google.golang.org/genproto/googleapis/ads/googleads/v1/services"
+)
+
+func TestUploadCallConversionsAbortBatch(t *testing.T) {
+	called := false
+	fake := &fakeConversionUploadServiceClient{
+		uploadCallConversions: func(_ context.Context, _ *servicespb.UploadCallConversionsRequest) (*servicespb.UploadCallConversionsResponse, error) {
+			called = true
+			return &servicespb.UploadCallConversionsResponse{}, nil
+		},
+	}
+	c := New(fake, "customers/1")
+	n := NewPhoneNormalizer("US")
+
+	result, err := c.UploadCallConversions(context.Background(), n, AbortBatch, []*servicespb.CallConversion{
+		{CallerId: "6502531234", ConversionAction: "action/1"},
+		{CallerId: "not-a-number ext 4", ConversionAction: "action/1"},
+		{CallerId: "+16502539999", ConversionAction: "action/1"},
+	})
+	if err != nil {
+		t.Fatalf("UploadCallConversions: %v", err)
+	}
+	if called {
+		t.Error("AbortBatch issued an RPC; want none")
+	}
+	if len(result.Rows) != 1 || result.Rows[0].Outcome != PermanentlyRejected {
+		t.Errorf("Rows = %+v, want exactly one PermanentlyRejected row for the unparseable caller ID", result.Rows)
+	}
+}
+
+func TestUploadCallConversionsKeepAndFailNotDoubleReported(t *testing.T) {
+	fake := &fakeConversionUploadServiceClient{
+		uploadCallConversions: func(_ context.Context, req *servicespb.UploadCallConversionsRequest) (*servicespb.UploadCallConversionsResponse, error) {
+			if len(req.GetConversions()) != 1 {
+				t.Fatalf("got %d conversions in request, want 1 (the unparseable row, kept per policy)", len(req.GetConversions()))
+			}
+			return &servicespb.UploadCallConversionsResponse{
+				PartialFailureError: buildPartialFailure(t, 0, errorspb.ConversionUploadErrorEnum_UNPARSEABLE_CALLERS_PHONE_NUMBER, "unparseable"),
+			}, nil
+		},
+	}
+	c := New(fake, "customers/1")
+	n := NewPhoneNormalizer("US")
+
+	result, err := c.UploadCallConversions(context.Background(), n, KeepAndFail, []*servicespb.CallConversion{
+		{CallerId: "not-a-number ext 4", ConversionAction: "action/1"},
+	})
+	if err != nil {
+		t.Fatalf("UploadCallConversions: %v", err)
+	}
+	if len(result.Rows) != 1 {
+		t.Fatalf("len(Rows) = %d, want 1 (the server-reported rejection only, not also a local one)", len(result.Rows))
+	}
+}
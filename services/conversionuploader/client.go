@@ -0,0 +1,386 @@
+// Package conversionuploader is a high-level client around
+// ConversionUploadService.UploadClickConversions. It chunks arbitrarily
+// large batches to respect the per-RPC conversion limit, deduplicates
+// rows that would otherwise collide on (order_id, conversion_action),
+// and uses conversionuploaderr to split a mixed response into rows a
+// caller can safely retry, must not retry, or must wait hours to retry.
+package conversionuploader
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/miaoyijin/dd_time/errors/conversionuploaderr"
+	servicespb "google.golang.org/genproto/googleapis/ads/googleads/v1/services"
+)
+
+// maxConversionsPerRequest is the TOO_MANY_CONVERSIONS_IN_REQUEST limit
+// enforced by ConversionUploadService.
+const maxConversionsPerRequest = 2000
+
+// Store persists rows that failed with a retryable "too recent" error —
+// for both click and call conversions — so their multi-hour retry delay
+// survives a process restart.
+type Store interface {
+	Save(ctx context.Context, pending PendingConversion) error
+	Load(ctx context.Context) ([]PendingConversion, error)
+	Delete(ctx context.Context, key string) error
+
+	SaveCall(ctx context.Context, pending PendingCallConversion) error
+	LoadCalls(ctx context.Context) ([]PendingCallConversion, error)
+	DeleteCall(ctx context.Context, key string) error
+}
+
+// PendingConversion is a single click conversion row waiting out a
+// scheduled retry.
+type PendingConversion struct {
+	// Key uniquely identifies this row for the Store. It is deliberately
+	// distinct from the (order_id, conversion_action) dedup key: gclid-only
+	// conversions routinely share an empty order_id and the same
+	// conversion action, so the dedup key alone would collide and one
+	// Save would silently clobber another row's pending retry. See
+	// clickRetryKey.
+	Key        string
+	Conversion *servicespb.ClickConversion
+	NotBefore  time.Time
+	Reason     error
+	// Attempt is how many times this row has already been scheduled for
+	// retry. It drives the exponential backoff applied the next time the
+	// row comes back with another retryable error: the delay doubles per
+	// attempt, up to maxRetryBackoff.
+	Attempt int
+}
+
+// maxRetryBackoff caps exponential backoff so a row that keeps failing
+// with a retryable error doesn't get scheduled further and further into
+// the future without bound.
+const maxRetryBackoff = 48 * time.Hour
+
+// backoffDelay grows base exponentially with attempt (base, 2*base,
+// 4*base, ...), capped at maxRetryBackoff.
+func backoffDelay(base time.Duration, attempt int) time.Duration {
+	if attempt <= 0 {
+		return base
+	}
+	const maxShift = 16 // guards against overflowing time.Duration on a pathological attempt count
+	shift := attempt
+	if shift > maxShift {
+		shift = maxShift
+	}
+	delay := base << uint(shift)
+	if delay <= 0 || delay > maxRetryBackoff {
+		return maxRetryBackoff
+	}
+	return delay
+}
+
+// clickRetryKey identifies a click conversion for the retry Store. Unlike
+// the (order_id, conversion_action) key dedupeClickConversions uses, it
+// also folds in the gclid: order_id is commonly empty for gclid-only
+// conversions, and two such rows that share a conversion action must not
+// collide in the Store the way they're deliberately allowed to pass
+// through dedup together.
+func clickRetryKey(conv *servicespb.ClickConversion) string {
+	return conv.GetOrderId() + "\x00" + conv.GetGclid() + "\x00" + conv.GetConversionAction()
+}
+
+// Outcome classifies how a single input row was handled.
+type Outcome int
+
+const (
+	Accepted Outcome = iota
+	PermanentlyRejected
+	PendingRetry
+)
+
+func (o Outcome) String() string {
+	switch o {
+	case Accepted:
+		return "accepted"
+	case PermanentlyRejected:
+		return "permanently rejected"
+	case PendingRetry:
+		return "pending retry"
+	default:
+		return "unknown"
+	}
+}
+
+// RowResult reports what happened to one input ClickConversion.
+type RowResult struct {
+	OrderID          string
+	ConversionAction string
+	Outcome          Outcome
+	// Reason is set for PermanentlyRejected and PendingRetry rows.
+	Reason error
+	// RetryAt is set for PendingRetry rows.
+	RetryAt time.Time
+	// key is the row's Store identity (clickRetryKey), used internally by
+	// DrainDueRetries to delete a row once it resolves to something other
+	// than another PendingRetry. It has no exported form since
+	// OrderID+ConversionAction alone can't distinguish gclid-only rows
+	// from each other.
+	key string
+}
+
+// Result is the outcome of an UploadClickConversions call, with one
+// RowResult per input row; rows dropped as duplicates are reported
+// PermanentlyRejected with ErrDuplicateOrderID. Rows of a chunk whose RPC
+// failed outright have no RowResult.
+type Result struct {
+	Rows []RowResult
+}
+
+// Client batches, chunks, and retries calls to
+// ConversionUploadService.UploadClickConversions behind a single Result,
+// regardless of how many chunks or retries it took under the hood.
+type Client struct {
+	svc         servicespb.ConversionUploadServiceClient
+	customerID  string
+	parallelism int
+	store       Store
+}
+
+// Option configures a Client returned by New.
+type Option func(*Client)
+
+// WithParallelism overrides the default of 4 concurrent chunk uploads.
+func WithParallelism(n int) Option {
+	return func(c *Client) {
+		if n > 0 {
+			c.parallelism = n
+		}
+	}
+}
+
+// WithStore persists rows that must wait out a "too recent" delay so they
+// survive process restarts. Without one, those rows are dropped after
+// being reported and DrainDueRetries has nothing to drain.
+func WithStore(s Store) Option {
+	return func(c *Client) { c.store = s }
+}
+
+// New returns a Client that uploads click conversions for customerID
+// through svc.
+func New(svc servicespb.ConversionUploadServiceClient, customerID string, opts ...Option) *Client {
+	c := &Client{svc: svc, customerID: customerID, parallelism: 4}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// UploadClickConversions uploads conversions, splitting them into chunks
+// of at most maxConversionsPerRequest and deduplicating by (order_id,
+// conversion_action) before send to preempt DUPLICATE_ORDER_ID. Chunks
+// are sent concurrently, bounded by the client's parallelism. Rows that
+// fail with a retryable ConversionUploadError are scheduled on the
+// configured Store rather than retried inline, since their delay is
+// measured in hours. The returned error is set when a whole chunk's RPC
+// failed outright, or when a chunk's partial failure includes an error
+// with no conversion index, in which case that chunk's per-row outcomes
+// are incomplete.
+func (c *Client) UploadClickConversions(ctx context.Context, conversions []*servicespb.ClickConversion) (*Result, error) {
+	return c.uploadClickConversions(ctx, conversions, nil)
+}
+
+// uploadClickConversions is UploadClickConversions with an additional,
+// internal-only attempts map (keyed by clickRetryKey) carrying forward how
+// many times each row has already been retried, so DrainDueRetries can
+// keep growing the backoff instead of resetting it on every call.
+func (c *Client) uploadClickConversions(ctx context.Context, conversions []*servicespb.ClickConversion, attempts map[string]int) (*Result, error) {
+	deduped, dupes := dedupeClickConversions(conversions)
+	chunks := chunkClickConversions(deduped, maxConversionsPerRequest)
+
+	var (
+		mu       sync.Mutex
+		wg       sync.WaitGroup
+		rows     = make([]RowResult, 0, len(deduped))
+		firstErr error
+	)
+
+	sem := make(chan struct{}, c.parallelism)
+	for _, chunk := range chunks {
+		chunk := chunk
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			chunkRows, err := c.uploadChunk(ctx, chunk, attempts)
+
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil && firstErr == nil {
+				firstErr = err
+			}
+			rows = append(rows, chunkRows...)
+		}()
+	}
+	wg.Wait()
+
+	for _, d := range dupes {
+		rows = append(rows, RowResult{
+			OrderID:          d.GetOrderId(),
+			ConversionAction: d.GetConversionAction(),
+			Outcome:          PermanentlyRejected,
+			Reason:           conversionuploaderr.ErrDuplicateOrderID,
+			key:              clickRetryKey(d),
+		})
+	}
+	return &Result{Rows: rows}, firstErr
+}
+
+func (c *Client) uploadChunk(ctx context.Context, chunk []*servicespb.ClickConversion, attempts map[string]int) ([]RowResult, error) {
+	resp, err := c.svc.UploadClickConversions(ctx, &servicespb.UploadClickConversionsRequest{
+		CustomerId:     c.customerID,
+		Conversions:    chunk,
+		PartialFailure: true,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// A failure with no conversion index (ConversionIndex == -1, see
+	// conversionIndex) can't be attributed to any row in chunk, so it's
+	// kept separate from failureByIndex rather than silently discarded:
+	// surfacing it as a chunk-level error tells the caller this chunk's
+	// per-row outcomes below are incomplete, instead of letting the row it
+	// was actually about pass through as Accepted.
+	failureByIndex := make(map[int32]conversionuploaderr.ConversionUploadError)
+	var unindexed []conversionuploaderr.ConversionUploadError
+	for _, f := range conversionuploaderr.FromStatus(resp.GetPartialFailureError()) {
+		if f.ConversionIndex < 0 {
+			unindexed = append(unindexed, f)
+			continue
+		}
+		failureByIndex[f.ConversionIndex] = f
+	}
+
+	rows := make([]RowResult, 0, len(chunk))
+	for i, conv := range chunk {
+		key := clickRetryKey(conv)
+		row := RowResult{OrderID: conv.GetOrderId(), ConversionAction: conv.GetConversionAction(), key: key}
+
+		failure, failed := failureByIndex[int32(i)]
+		if !failed {
+			row.Outcome = Accepted
+			rows = append(rows, row)
+			continue
+		}
+
+		cuErr := failure.WithContext(conv.GetConversionAction(), conv.GetGclid())
+		row.Reason = cuErr
+		if retryable, retryAfter, _ := conversionuploaderr.Classify(cuErr); retryable {
+			attempt := attempts[key]
+			row.Outcome = PendingRetry
+			row.RetryAt = time.Now().Add(backoffDelay(retryAfter, attempt))
+			if c.store != nil {
+				_ = c.store.Save(ctx, PendingConversion{Key: key, Conversion: conv, NotBefore: row.RetryAt, Reason: cuErr, Attempt: attempt + 1})
+			}
+		} else {
+			row.Outcome = PermanentlyRejected
+		}
+		rows = append(rows, row)
+	}
+
+	var chunkErr error
+	if len(unindexed) > 0 {
+		chunkErr = fmt.Errorf("conversionuploader: %d partial failure(s) with no conversion index, e.g. %w", len(unindexed), &unindexed[0])
+	}
+	return rows, chunkErr
+}
+
+// DrainDueRetries re-uploads every Store row, click or call, whose
+// NotBefore has passed, removing rows from Store as they resolve to
+// something other than another PendingRetry.
+func (c *Client) DrainDueRetries(ctx context.Context) (*Result, error) {
+	if c.store == nil {
+		return &Result{}, nil
+	}
+
+	clickResult, clickErr := c.drainDueClickRetries(ctx)
+	callResult, callErr := c.drainDueCallRetries(ctx)
+
+	rows := append(clickResult.Rows, callResult.Rows...)
+	if clickErr != nil {
+		return &Result{Rows: rows}, clickErr
+	}
+	return &Result{Rows: rows}, callErr
+}
+
+func (c *Client) drainDueClickRetries(ctx context.Context) (*Result, error) {
+	pending, err := c.store.Load(ctx)
+	if err != nil {
+		return &Result{}, fmt.Errorf("conversionuploader: loading pending click retries: %w", err)
+	}
+
+	now := time.Now()
+	due := make([]*servicespb.ClickConversion, 0, len(pending))
+	attempts := make(map[string]int, len(pending))
+	for _, p := range pending {
+		if now.Before(p.NotBefore) {
+			continue
+		}
+		due = append(due, p.Conversion)
+		attempts[clickRetryKey(p.Conversion)] = p.Attempt
+	}
+	if len(due) == 0 {
+		return &Result{}, nil
+	}
+
+	result, err := c.uploadClickConversions(ctx, due, attempts)
+	if err != nil {
+		return result, err
+	}
+	for _, row := range result.Rows {
+		if row.Outcome == PendingRetry {
+			continue
+		}
+		_ = c.store.Delete(ctx, row.key)
+	}
+	return result, nil
+}
+
+// dedupeClickConversions drops rows that collide on (order_id,
+// conversion_action), since the server would reject the whole batch with
+// DUPLICATE_ORDER_ID otherwise. order_id is optional on ClickConversion
+// (gclid-only conversions routinely omit it), and only a non-empty
+// order_id can trigger DUPLICATE_ORDER_ID, so rows without one are never
+// deduplicated against each other.
+func dedupeClickConversions(in []*servicespb.ClickConversion) (deduped, dupes []*servicespb.ClickConversion) {
+	seen := make(map[string]bool, len(in))
+	for _, conv := range in {
+		orderID := conv.GetOrderId()
+		if orderID == "" {
+			deduped = append(deduped, conv)
+			continue
+		}
+		key := orderID + "\x00" + conv.GetConversionAction()
+		if seen[key] {
+			dupes = append(dupes, conv)
+			continue
+		}
+		seen[key] = true
+		deduped = append(deduped, conv)
+	}
+	return deduped, dupes
+}
+
+func chunkClickConversions(in []*servicespb.ClickConversion, size int) [][]*servicespb.ClickConversion {
+	if len(in) == 0 {
+		return nil
+	}
+	chunks := make([][]*servicespb.ClickConversion, 0, (len(in)+size-1)/size)
+	for i := 0; i < len(in); i += size {
+		end := i + size
+		if end > len(in) {
+			end = len(in)
+		}
+		chunks = append(chunks, in[i:end])
+	}
+	return chunks
+}
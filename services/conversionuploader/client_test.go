@@ -0,0 +1,381 @@
+package conversionuploader
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/ptypes"
+	errorspb "google.golang.org/genproto/googleapis/ads/googleads/v1/errors"
+	servicespb "google.golang.org/genproto/googleapis/ads/googleads/v1/services"
+	"google.golang.org/genproto/googleapis/rpc/status"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+// fakeConversionUploadServiceClient is a test double for
+// servicespb.ConversionUploadServiceClient; tests set whichever of the
+// two funcs they need and leave the other nil.
+type fakeConversionUploadServiceClient struct {
+	uploadClickConversions func(ctx context.Context, req *servicespb.UploadClickConversionsRequest) (*servicespb.UploadClickConversionsResponse, error)
+	uploadCallConversions  func(ctx context.Context, req *servicespb.UploadCallConversionsRequest) (*servicespb.UploadCallConversionsResponse, error)
+}
+
+func (f *fakeConversionUploadServiceClient) UploadClickConversions(ctx context.Context, req *servicespb.UploadClickConversionsRequest, _ ...grpc.CallOption) (*servicespb.UploadClickConversionsResponse, error) {
+	return f.uploadClickConversions(ctx, req)
+}
+
+func (f *fakeConversionUploadServiceClient) UploadCallConversions(ctx context.Context, req *servicespb.UploadCallConversionsRequest, _ ...grpc.CallOption) (*servicespb.UploadCallConversionsResponse, error) {
+	return f.uploadCallConversions(ctx, req)
+}
+
+// buildPartialFailure packs a single-row GoogleAdsFailure into a
+// google.rpc.Status the way a real PartialFailureError would arrive.
+func buildPartialFailure(t *testing.T, index int64, code errorspb.ConversionUploadErrorEnum_ConversionUploadError, message string) *status.Status {
+	t.Helper()
+	failure := &errorspb.GoogleAdsFailure{
+		Errors: []*errorspb.GoogleAdsError{
+			{
+				ErrorCode: &errorspb.GoogleAdsError_ConversionUploadError{ConversionUploadError: code},
+				Message:   message,
+				Location: &errorspb.ErrorLocation{
+					FieldPathElements: []*errorspb.ErrorLocation_FieldPathElement{
+						{FieldName: "conversions", Index: wrapperspb.Int64(index)},
+					},
+				},
+			},
+		},
+	}
+	anyMsg, err := ptypes.MarshalAny(failure)
+	if err != nil {
+		t.Fatalf("MarshalAny: %v", err)
+	}
+	st := &status.Status{}
+	st.Details = append(st.Details, anyMsg)
+	return st
+}
+
+func TestDedupeClickConversionsSkipsEmptyOrderID(t *testing.T) {
+	in := []*servicespb.ClickConversion{
+		{ConversionAction: "action/1", Gclid: "g1"},
+		{ConversionAction: "action/1", Gclid: "g2"},
+		{OrderId: "order-1", ConversionAction: "action/1"},
+		{OrderId: "order-1", ConversionAction: "action/1"},
+	}
+	deduped, dupes := dedupeClickConversions(in)
+	if len(deduped) != 3 {
+		t.Fatalf("len(deduped) = %d, want 3 (both empty-order_id rows kept, one of the order-1 pair dropped)", len(deduped))
+	}
+	if len(dupes) != 1 {
+		t.Fatalf("len(dupes) = %d, want 1", len(dupes))
+	}
+	if dupes[0].GetOrderId() != "order-1" {
+		t.Errorf("dupes[0].OrderId = %q, want %q", dupes[0].GetOrderId(), "order-1")
+	}
+}
+
+func TestChunkClickConversions(t *testing.T) {
+	in := make([]*servicespb.ClickConversion, 2500)
+	for i := range in {
+		in[i] = &servicespb.ClickConversion{Gclid: fmt.Sprintf("g%d", i)}
+	}
+	chunks := chunkClickConversions(in, maxConversionsPerRequest)
+	if len(chunks) != 2 {
+		t.Fatalf("len(chunks) = %d, want 2", len(chunks))
+	}
+	if len(chunks[0]) != maxConversionsPerRequest || len(chunks[1]) != 500 {
+		t.Errorf("chunk sizes = %d, %d, want %d, 500", len(chunks[0]), len(chunks[1]), maxConversionsPerRequest)
+	}
+}
+
+func TestChunkClickConversionsEmpty(t *testing.T) {
+	if chunks := chunkClickConversions(nil, maxConversionsPerRequest); chunks != nil {
+		t.Errorf("chunkClickConversions(nil) = %v, want nil", chunks)
+	}
+}
+
+func TestBackoffDelay(t *testing.T) {
+	cases := []struct {
+		attempt int
+		want    time.Duration
+	}{
+		{0, 6 * time.Hour},
+		{1, 12 * time.Hour},
+		{2, 24 * time.Hour},
+		{3, maxRetryBackoff},
+		{100, maxRetryBackoff},
+	}
+	for _, tc := range cases {
+		if got := backoffDelay(6*time.Hour, tc.attempt); got != tc.want {
+			t.Errorf("backoffDelay(6h, %d) = %v, want %v", tc.attempt, got, tc.want)
+		}
+	}
+}
+
+func TestUploadClickConversionsPartialFailure(t *testing.T) {
+	fake := &fakeConversionUploadServiceClient{
+		uploadClickConversions: func(_ context.Context, req *servicespb.UploadClickConversionsRequest) (*servicespb.UploadClickConversionsResponse, error) {
+			if len(req.GetConversions()) != 2 {
+				t.Fatalf("got %d conversions in request, want 2", len(req.GetConversions()))
+			}
+			return &servicespb.UploadClickConversionsResponse{
+				PartialFailureError: buildPartialFailure(t, 1, errorspb.ConversionUploadErrorEnum_TOO_RECENT_GCLID, "too recent"),
+			}, nil
+		},
+	}
+	c := New(fake, "customers/1")
+
+	result, err := c.UploadClickConversions(context.Background(), []*servicespb.ClickConversion{
+		{Gclid: "g1", ConversionAction: "action/1"},
+		{Gclid: "g2", ConversionAction: "action/1"},
+	})
+	if err != nil {
+		t.Fatalf("UploadClickConversions: %v", err)
+	}
+	if len(result.Rows) != 2 {
+		t.Fatalf("len(Rows) = %d, want 2", len(result.Rows))
+	}
+	if result.Rows[0].Outcome != Accepted {
+		t.Errorf("Rows[0].Outcome = %v, want Accepted", result.Rows[0].Outcome)
+	}
+	if result.Rows[1].Outcome != PendingRetry {
+		t.Errorf("Rows[1].Outcome = %v, want PendingRetry", result.Rows[1].Outcome)
+	}
+	if result.Rows[1].RetryAt.Before(time.Now().Add(5 * time.Hour)) {
+		t.Errorf("Rows[1].RetryAt = %v, want at least ~6h out", result.Rows[1].RetryAt)
+	}
+}
+
+func TestUploadClickConversionsPermanentFailure(t *testing.T) {
+	fake := &fakeConversionUploadServiceClient{
+		uploadClickConversions: func(_ context.Context, req *servicespb.UploadClickConversionsRequest) (*servicespb.UploadClickConversionsResponse, error) {
+			return &servicespb.UploadClickConversionsResponse{
+				PartialFailureError: buildPartialFailure(t, 0, errorspb.ConversionUploadErrorEnum_UNPARSEABLE_GCLID, "bad gclid"),
+			}, nil
+		},
+	}
+	c := New(fake, "customers/1")
+
+	result, err := c.UploadClickConversions(context.Background(), []*servicespb.ClickConversion{
+		{Gclid: "not-a-gclid", ConversionAction: "action/1"},
+	})
+	if err != nil {
+		t.Fatalf("UploadClickConversions: %v", err)
+	}
+	if len(result.Rows) != 1 || result.Rows[0].Outcome != PermanentlyRejected {
+		t.Errorf("Rows = %+v, want a single PermanentlyRejected row", result.Rows)
+	}
+}
+
+// fakeStore is an in-memory Store for tests, keyed by each pending row's
+// own Key field rather than recomputing it, the way a real Store would be.
+type fakeStore struct {
+	mu           sync.Mutex
+	pending      map[string]PendingConversion
+	pendingCalls map[string]PendingCallConversion
+}
+
+func newFakeStore() *fakeStore {
+	return &fakeStore{
+		pending:      make(map[string]PendingConversion),
+		pendingCalls: make(map[string]PendingCallConversion),
+	}
+}
+
+func (s *fakeStore) Save(_ context.Context, p PendingConversion) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pending[p.Key] = p
+	return nil
+}
+
+func (s *fakeStore) Load(_ context.Context) ([]PendingConversion, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := make([]PendingConversion, 0, len(s.pending))
+	for _, p := range s.pending {
+		out = append(out, p)
+	}
+	return out, nil
+}
+
+func (s *fakeStore) Delete(_ context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.pending, key)
+	return nil
+}
+
+func (s *fakeStore) SaveCall(_ context.Context, p PendingCallConversion) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.pendingCalls[p.Key] = p
+	return nil
+}
+
+func (s *fakeStore) LoadCalls(_ context.Context) ([]PendingCallConversion, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := make([]PendingCallConversion, 0, len(s.pendingCalls))
+	for _, p := range s.pendingCalls {
+		out = append(out, p)
+	}
+	return out, nil
+}
+
+func (s *fakeStore) DeleteCall(_ context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.pendingCalls, key)
+	return nil
+}
+
+func (s *fakeStore) get(key string) PendingConversion {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.pending[key]
+}
+
+func (s *fakeStore) forceDue(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p := s.pending[key]
+	p.NotBefore = time.Now().Add(-time.Minute)
+	s.pending[key] = p
+}
+
+// TestDrainDueRetriesGrowsBackoff exercises two consecutive retryable
+// failures for the same row and checks that the scheduled delay grows
+// instead of resetting to the same flat 6h every time.
+func TestDrainDueRetriesGrowsBackoff(t *testing.T) {
+	store := newFakeStore()
+	fake := &fakeConversionUploadServiceClient{
+		uploadClickConversions: func(_ context.Context, req *servicespb.UploadClickConversionsRequest) (*servicespb.UploadClickConversionsResponse, error) {
+			return &servicespb.UploadClickConversionsResponse{
+				PartialFailureError: buildPartialFailure(t, 0, errorspb.ConversionUploadErrorEnum_TOO_RECENT_GCLID, "too recent"),
+			}, nil
+		},
+	}
+	c := New(fake, "customers/1", WithStore(store))
+
+	conv := &servicespb.ClickConversion{Gclid: "g1", ConversionAction: "action/1", OrderId: "order-1"}
+	key := clickRetryKey(conv)
+	if _, err := c.UploadClickConversions(context.Background(), []*servicespb.ClickConversion{conv}); err != nil {
+		t.Fatalf("first upload: %v", err)
+	}
+	first := store.get(key)
+	if first.Attempt != 1 {
+		t.Fatalf("after first failure, Attempt = %d, want 1", first.Attempt)
+	}
+	firstDelay := time.Until(first.NotBefore)
+
+	store.forceDue(key)
+	if _, err := c.DrainDueRetries(context.Background()); err != nil {
+		t.Fatalf("DrainDueRetries: %v", err)
+	}
+	second := store.get(key)
+	if second.Attempt != 2 {
+		t.Fatalf("after second failure, Attempt = %d, want 2", second.Attempt)
+	}
+	secondDelay := time.Until(second.NotBefore)
+	if secondDelay < firstDelay+5*time.Hour {
+		t.Errorf("second backoff (%v) did not grow past first (%v) as expected from doubling", secondDelay, firstDelay)
+	}
+}
+
+func TestDrainDueRetriesSkipsNotYetDue(t *testing.T) {
+	store := newFakeStore()
+	called := false
+	fake := &fakeConversionUploadServiceClient{
+		uploadClickConversions: func(_ context.Context, _ *servicespb.UploadClickConversionsRequest) (*servicespb.UploadClickConversionsResponse, error) {
+			called = true
+			return &servicespb.UploadClickConversionsResponse{}, nil
+		},
+	}
+	c := New(fake, "customers/1", WithStore(store))
+	conv := &servicespb.ClickConversion{Gclid: "g1", ConversionAction: "action/1", OrderId: "order-1"}
+	_ = store.Save(context.Background(), PendingConversion{
+		Key:        clickRetryKey(conv),
+		Conversion: conv,
+		NotBefore:  time.Now().Add(time.Hour),
+	})
+
+	if _, err := c.DrainDueRetries(context.Background()); err != nil {
+		t.Fatalf("DrainDueRetries: %v", err)
+	}
+	if called {
+		t.Error("DrainDueRetries uploaded a row whose NotBefore had not passed yet")
+	}
+}
+
+// TestUploadClickConversionsStoresBothEmptyOrderIDRows guards against the
+// Store clobbering one gclid-only row's pending retry with another's: two
+// distinct conversions that both fail TOO_RECENT_GCLID for the same
+// conversion action, with no order_id, must end up as two separate Store
+// entries, not one.
+func TestUploadClickConversionsStoresBothEmptyOrderIDRows(t *testing.T) {
+	store := newFakeStore()
+	fake := &fakeConversionUploadServiceClient{
+		uploadClickConversions: func(_ context.Context, req *servicespb.UploadClickConversionsRequest) (*servicespb.UploadClickConversionsResponse, error) {
+			return &servicespb.UploadClickConversionsResponse{
+				PartialFailureError: buildPartialFailure(t, 0, errorspb.ConversionUploadErrorEnum_TOO_RECENT_GCLID, "too recent"),
+			}, nil
+		},
+	}
+	c := New(fake, "customers/1", WithStore(store))
+
+	convA := &servicespb.ClickConversion{Gclid: "gA", ConversionAction: "action/1"}
+	convB := &servicespb.ClickConversion{Gclid: "gB", ConversionAction: "action/1"}
+	if _, err := c.UploadClickConversions(context.Background(), []*servicespb.ClickConversion{convA}); err != nil {
+		t.Fatalf("upload convA: %v", err)
+	}
+	if _, err := c.UploadClickConversions(context.Background(), []*servicespb.ClickConversion{convB}); err != nil {
+		t.Fatalf("upload convB: %v", err)
+	}
+
+	if got := store.get(clickRetryKey(convA)); got.Conversion == nil {
+		t.Error("convA's pending retry was clobbered by convB's Save")
+	}
+	if got := store.get(clickRetryKey(convB)); got.Conversion == nil {
+		t.Error("convB's pending retry was clobbered by convA's Save")
+	}
+}
+
+// TestUploadClickConversionsUnindexedFailureSurfaced guards against a
+// partial failure with no conversion index being silently dropped: it
+// must come back as a chunk-level error instead of letting the row it was
+// actually about masquerade as Accepted.
+func TestUploadClickConversionsUnindexedFailureSurfaced(t *testing.T) {
+	st := buildPartialFailure(t, 0, errorspb.ConversionUploadErrorEnum_UNPARSEABLE_GCLID, "bad gclid")
+	// Strip the index so the failure can't be attributed to a row, the way
+	// a malformed or unusual server response might.
+	failure := &errorspb.GoogleAdsFailure{}
+	if err := ptypes.UnmarshalAny(st.Details[0], failure); err != nil {
+		t.Fatalf("UnmarshalAny: %v", err)
+	}
+	failure.Errors[0].Location.FieldPathElements[0].Index = nil
+	anyMsg, err := ptypes.MarshalAny(failure)
+	if err != nil {
+		t.Fatalf("MarshalAny: %v", err)
+	}
+	st.Details[0] = anyMsg
+
+	fake := &fakeConversionUploadServiceClient{
+		uploadClickConversions: func(_ context.Context, _ *servicespb.UploadClickConversionsRequest) (*servicespb.UploadClickConversionsResponse, error) {
+			return &servicespb.UploadClickConversionsResponse{PartialFailureError: st}, nil
+		},
+	}
+	c := New(fake, "customers/1")
+
+	result, err := c.UploadClickConversions(context.Background(), []*servicespb.ClickConversion{
+		{Gclid: "not-a-gclid", ConversionAction: "action/1"},
+	})
+	if err == nil {
+		t.Fatal("UploadClickConversions returned no error for an unindexed partial failure, want one")
+	}
+	if len(result.Rows) != 1 || result.Rows[0].Outcome != Accepted {
+		t.Fatalf("Rows = %+v, want the lone row still reported Accepted (its real outcome is unknown, which is exactly why the error must be checked)", result.Rows)
+	}
+}
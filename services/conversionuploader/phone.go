@@ -0,0 +1,134 @@
+package conversionuploader
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	servicespb "google.golang.org/genproto/googleapis/ads/googleads/v1/services"
+)
+
+// Policy controls how NormalizeRequest handles a CallConversion whose
+// caller ID fails to parse.
+type Policy int
+
+const (
+	// Drop removes the row from the request; the caller only learns
+	// about it via the returned []PhoneParseError.
+	Drop Policy = iota
+	// KeepAndFail leaves the row in the request untouched, so the RPC
+	// will reject it server-side with UNPARSEABLE_CALLERS_PHONE_NUMBER.
+	KeepAndFail
+	// AbortBatch stops normalizing at the first failure and leaves the
+	// request untouched; callers should treat a non-empty return as
+	// "do not send this batch".
+	AbortBatch
+)
+
+// PhoneParseError reports why a caller ID could not be normalized to
+// E.164.
+type PhoneParseError struct {
+	Original string
+	Reason   string
+}
+
+func (e *PhoneParseError) Error() string {
+	return fmt.Sprintf("conversionuploader: phone number %q: %s", e.Original, e.Reason)
+}
+
+// PhoneNormalizer rewrites CallConversion.CallerId values into E.164
+// before a request is sent, so UNPARSEABLE_CALLERS_PHONE_NUMBER becomes a
+// local, synchronous validation failure instead of a wasted round trip.
+type PhoneNormalizer struct {
+	// DefaultRegion is used to interpret national-format numbers, e.g.
+	// "6502531234" under DefaultRegion "US".
+	DefaultRegion string
+}
+
+// NewPhoneNormalizer returns a PhoneNormalizer that interprets
+// national-format numbers as belonging to defaultRegion (e.g. "US").
+func NewPhoneNormalizer(defaultRegion string) *PhoneNormalizer {
+	return &PhoneNormalizer{DefaultRegion: defaultRegion}
+}
+
+// countryCallingCodes maps the regions this package knows how to
+// interpret national-format numbers for. Extend as new regions are
+// needed; anything else requires callers to pass E.164 or international
+// format directly.
+var countryCallingCodes = map[string]string{
+	"US": "1",
+	"CA": "1",
+	"GB": "44",
+	"AU": "61",
+	"NZ": "64",
+}
+
+var nonDigits = regexp.MustCompile(`[^\d+]`)
+
+// Normalize rewrites raw into E.164 ("+16502531234"), accepting E.164,
+// loosely-punctuated international format ("+64 3-331 6005"), or a
+// national-format number interpreted under n.DefaultRegion
+// ("6502531234").
+func (n *PhoneNormalizer) Normalize(raw string) (string, *PhoneParseError) {
+	if strings.ContainsAny(raw, "xX") || strings.Contains(strings.ToLower(raw), "ext") {
+		return "", &PhoneParseError{Original: raw, Reason: "extensions are not supported"}
+	}
+
+	cleaned := nonDigits.ReplaceAllString(raw, "")
+	if cleaned == "" {
+		return "", &PhoneParseError{Original: raw, Reason: "no digits found"}
+	}
+
+	if strings.HasPrefix(cleaned, "+") {
+		digits := cleaned[1:]
+		if len(digits) < 8 || len(digits) > 15 {
+			return "", &PhoneParseError{Original: raw, Reason: "expected 8-15 digits after the leading +"}
+		}
+		return "+" + digits, nil
+	}
+
+	code, ok := countryCallingCodes[n.DefaultRegion]
+	if !ok {
+		return "", &PhoneParseError{Original: raw, Reason: fmt.Sprintf("unsupported default region %q for a national-format number", n.DefaultRegion)}
+	}
+	if len(cleaned) < 8 || len(cleaned) > 15 {
+		return "", &PhoneParseError{Original: raw, Reason: "expected 8-15 digits in national format"}
+	}
+	return "+" + code + cleaned, nil
+}
+
+// NormalizeRequest normalizes every CallConversion.CallerId in req in
+// place. Rows that fail to parse are handled per policy: Drop removes
+// them from req.Conversions, KeepAndFail leaves them for the server to
+// reject, and AbortBatch stops at the first failure without modifying
+// req.
+func (n *PhoneNormalizer) NormalizeRequest(req *servicespb.UploadCallConversionsRequest, policy Policy) []PhoneParseError {
+	if req == nil {
+		return nil
+	}
+
+	var errs []PhoneParseError
+	kept := req.GetConversions()[:0]
+	callerIDs := make([]string, 0, len(req.GetConversions()))
+	for _, conv := range req.GetConversions() {
+		normalized, err := n.Normalize(conv.GetCallerId())
+		if err != nil {
+			errs = append(errs, *err)
+			if policy == AbortBatch {
+				return errs
+			}
+			if policy == KeepAndFail {
+				kept = append(kept, conv)
+				callerIDs = append(callerIDs, conv.GetCallerId())
+			}
+			continue
+		}
+		kept = append(kept, conv)
+		callerIDs = append(callerIDs, normalized)
+	}
+	for i, conv := range kept {
+		conv.CallerId = callerIDs[i]
+	}
+	req.Conversions = kept
+	return errs
+}
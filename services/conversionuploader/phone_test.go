@@ -0,0 +1,123 @@
+package conversionuploader
+
+import (
+	"testing"
+
+	servicespb "google.golang.org/genproto/googleapis/ads/googleads/v1/services"
+)
+
+func TestPhoneNormalizerNormalize(t *testing.T) {
+	n := NewPhoneNormalizer("US")
+	cases := []struct {
+		name    string
+		raw     string
+		want    string
+		wantErr bool
+	}{
+		{"e164", "+16502531234", "+16502531234", false},
+		{"punctuated_international", "+64 3-331 6005", "+6433316005", false},
+		{"national_us", "6502531234", "+16502531234", false},
+		{"extension_rejected", "650-253-1234 ext. 22", "", true},
+		{"too_short_e164", "+1650", "", true},
+		{"too_short_national", "650253", "", true},
+		{"empty", "", "", true},
+		{"unsupported_region", "012345678", "", true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			region := n
+			if tc.name == "unsupported_region" {
+				region = NewPhoneNormalizer("ZZ")
+			}
+			got, err := region.Normalize(tc.raw)
+			if tc.wantErr {
+				if err == nil {
+					t.Fatalf("Normalize(%q) = %q, nil, want an error", tc.raw, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Normalize(%q) returned error: %v", tc.raw, err)
+			}
+			if got != tc.want {
+				t.Errorf("Normalize(%q) = %q, want %q", tc.raw, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestNormalizeRequestDrop(t *testing.T) {
+	n := NewPhoneNormalizer("US")
+	req := &servicespb.UploadCallConversionsRequest{
+		Conversions: []*servicespb.CallConversion{
+			{CallerId: "6502531234"},
+			{CallerId: "not-a-number ext 4"},
+			{CallerId: "+16502539999"},
+		},
+	}
+	errs := n.NormalizeRequest(req, Drop)
+	if len(errs) != 1 {
+		t.Fatalf("len(errs) = %d, want 1", len(errs))
+	}
+	if len(req.Conversions) != 2 {
+		t.Fatalf("len(req.Conversions) = %d, want 2 (bad row dropped)", len(req.Conversions))
+	}
+	for _, conv := range req.Conversions {
+		if conv.GetCallerId()[0] != '+' {
+			t.Errorf("CallerId %q was not normalized to E.164", conv.GetCallerId())
+		}
+	}
+}
+
+func TestNormalizeRequestKeepAndFail(t *testing.T) {
+	n := NewPhoneNormalizer("US")
+	req := &servicespb.UploadCallConversionsRequest{
+		Conversions: []*servicespb.CallConversion{
+			{CallerId: "6502531234"},
+			{CallerId: "not-a-number ext 4"},
+		},
+	}
+	errs := n.NormalizeRequest(req, KeepAndFail)
+	if len(errs) != 1 {
+		t.Fatalf("len(errs) = %d, want 1", len(errs))
+	}
+	if len(req.Conversions) != 2 {
+		t.Fatalf("len(req.Conversions) = %d, want 2 (bad row kept for the server to reject)", len(req.Conversions))
+	}
+	if req.Conversions[1].GetCallerId() != "not-a-number ext 4" {
+		t.Errorf("KeepAndFail row was modified: %q", req.Conversions[1].GetCallerId())
+	}
+}
+
+func TestNormalizeRequestAbortBatch(t *testing.T) {
+	n := NewPhoneNormalizer("US")
+	original := []*servicespb.CallConversion{
+		{CallerId: "6502531234"},
+		{CallerId: "not-a-number ext 4"},
+		{CallerId: "+16502539999"},
+	}
+	req := &servicespb.UploadCallConversionsRequest{Conversions: original}
+	errs := n.NormalizeRequest(req, AbortBatch)
+	if len(errs) != 1 {
+		t.Fatalf("len(errs) = %d, want 1", len(errs))
+	}
+	if len(req.Conversions) != 3 || req.Conversions[0].GetCallerId() != "6502531234" {
+		t.Errorf("AbortBatch modified req.Conversions: %+v", req.Conversions)
+	}
+}
+
+func TestDedupeCallConversionsSkipsEmptyOrderID(t *testing.T) {
+	in := []*servicespb.CallConversion{
+		{ConversionAction: "action/1", CallerId: "+16502531234"},
+		{ConversionAction: "action/1", CallerId: "+16502539999"},
+		{OrderId: "order-1", ConversionAction: "action/1"},
+		{OrderId: "order-1", ConversionAction: "action/1"},
+	}
+	deduped, dupes := dedupeCallConversions(in)
+	if len(deduped) != 3 {
+		t.Fatalf("len(deduped) = %d, want 3", len(deduped))
+	}
+	if len(dupes) != 1 {
+		t.Fatalf("len(dupes) = %d, want 1", len(dupes))
+	}
+}